@@ -0,0 +1,272 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"sigs.k8s.io/kwok/pkg/log"
+	"sigs.k8s.io/kwok/pkg/utils/yaml"
+)
+
+// DiffOptions holds the configurable behavior of Diff.
+type DiffOptions struct {
+	// IgnorePaths lists additional dot-separated field paths (e.g.
+	// "spec.replicas") to exclude from the comparison, on top of
+	// DefaultDiffIgnorePaths.
+	IgnorePaths []string
+	// Transformers run, in order, on every decoded object before it is
+	// diffed, exactly as Load runs them before applying. Set this to the same
+	// Transformers a subsequent Load call will use, so Diff previews what
+	// will actually be applied.
+	Transformers []Transformer
+}
+
+// DefaultDiffIgnorePaths are always excluded from the comparison, since the
+// server rewrites them and they never reflect a meaningful difference.
+var DefaultDiffIgnorePaths = []string{
+	"metadata.resourceVersion",
+	"metadata.managedFields",
+	"metadata.uid",
+	"status",
+}
+
+// ResourceDiff is the result of comparing one object from a snapshot against
+// its live state in the cluster.
+type ResourceDiff struct {
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+	// Exists reports whether the object already existed in the cluster.
+	Exists bool
+	// Diff is a unified-style diff of the live object against the object that
+	// would result from applying the snapshot. Empty when there is no difference.
+	Diff string
+}
+
+// String renders d in unified-diff form, for CLI use. Empty when there is no
+// difference to show.
+func (d ResourceDiff) String() string {
+	if d.Diff == "" {
+		return ""
+	}
+	name := fmt.Sprintf("%s/%s", d.Namespace, d.Name)
+	return fmt.Sprintf("--- %s %s (live)\n+++ %s %s (snapshot)\n%s", d.GroupVersionKind.Kind, name, d.GroupVersionKind.Kind, name, d.Diff)
+}
+
+// Diff decodes the YAML stream from r and, for each object that passes
+// filters, computes a diff against the live cluster state by issuing a
+// dry-run apply and comparing the result to the object currently in the
+// cluster. It never mutates the cluster.
+func Diff(ctx context.Context, kubeconfigPath string, r io.Reader, filters []string, opts DiffOptions) ([]ResourceDiff, error) {
+	l, err := newLoader(kubeconfigPath, filters, LoadOptions{DryRun: true, Transformers: opts.Transformers})
+	if err != nil {
+		return nil, err
+	}
+
+	ignorePaths := diffIgnorePaths(opts.IgnorePaths)
+	logger := log.FromContext(ctx)
+	decoder := yaml.NewDecoder(r)
+
+	var diffs []ResourceDiff
+	err = decoder.Decode(func(obj *unstructured.Unstructured) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !l.filter(obj) {
+			logger.Info("skipped",
+				"resource", "filtered",
+				"kind", obj.GetKind(),
+				"name", log.KObj(obj),
+			)
+			return nil
+		}
+
+		rd, err := l.diffOne(ctx, obj, ignorePaths)
+		if err != nil {
+			return err
+		}
+		diffs = append(diffs, rd)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode objects: %w", err)
+	}
+	return diffs, nil
+}
+
+// diffIgnorePaths splits DefaultDiffIgnorePaths and extra into dot-separated
+// field paths.
+func diffIgnorePaths(extra []string) [][]string {
+	paths := append(append([]string{}, DefaultDiffIgnorePaths...), extra...)
+	out := make([][]string, 0, len(paths))
+	for _, p := range paths {
+		out = append(out, strings.Split(p, "."))
+	}
+	return out
+}
+
+// diffOne runs obj through the same Transformer and owner-reference
+// rewriting that load applies, fetches the live state (if any), dry-run
+// applies the result, and diffs the two after stripping ignorePaths from
+// both. This keeps the preview faithful to what Load will actually apply.
+func (l *loader) diffOne(ctx context.Context, obj *unstructured.Unstructured, ignorePaths [][]string) (ResourceDiff, error) {
+	if err := l.transform(obj); err != nil {
+		return ResourceDiff{}, fmt.Errorf("failed to transform %s %s: %w", obj.GetKind(), log.KObj(obj), err)
+	}
+	l.updateOwnerReferences(obj)
+
+	rd := ResourceDiff{
+		GroupVersionKind: obj.GroupVersionKind(),
+		Namespace:        obj.GetNamespace(),
+		Name:             obj.GetName(),
+	}
+
+	live, err := l.getLive(ctx, obj)
+	if err != nil {
+		return rd, err
+	}
+	rd.Exists = live != nil
+
+	after := l.apply(ctx, obj.DeepCopy())
+	if after == nil {
+		return rd, fmt.Errorf("failed to dry-run apply %s %s", obj.GetKind(), log.KObj(obj))
+	}
+
+	// Record the post-transform identity, same as load, so that an object
+	// later in the stream can resolve an owner reference to this one.
+	key := uniqueKeyFromMetadata(obj)
+	l.exist[key] = after.GetUID()
+	l.applied = append(l.applied, after)
+
+	before := live
+	if before == nil {
+		before = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	}
+
+	for _, path := range ignorePaths {
+		unstructured.RemoveNestedField(before.Object, path...)
+		unstructured.RemoveNestedField(after.Object, path...)
+	}
+
+	diffText, err := jsonDiff(before.Object, after.Object)
+	if err != nil {
+		return rd, err
+	}
+	rd.Diff = diffText
+	return rd, nil
+}
+
+// getLive fetches the current cluster state of obj, returning (nil, nil) if
+// it does not exist.
+func (l *loader) getLive(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvr := obj.GroupVersionKind().GroupVersion().WithResource(obj.GetKind())
+	gvr, err := l.restMapper.ResourceFor(gvr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource: %w", err)
+	}
+
+	nri := l.dynClient.Resource(gvr)
+	var ri dynamic.ResourceInterface = nri
+	if ns := obj.GetNamespace(); ns != "" {
+		ri = nri.Namespace(ns)
+	}
+
+	live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return live, nil
+}
+
+// jsonDiff renders a unified-style, line-based diff between before and after.
+// Returns an empty string when the two are identical.
+func jsonDiff(before, after map[string]interface{}) (string, error) {
+	beforeJSON, err := json.MarshalIndent(before, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal live object: %w", err)
+	}
+	afterJSON, err := json.MarshalIndent(after, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal applied object: %w", err)
+	}
+	if string(beforeJSON) == string(afterJSON) {
+		return "", nil
+	}
+	return unifiedLines(string(beforeJSON), string(afterJSON)), nil
+}
+
+// unifiedLines renders a line-based diff of before and after, prefixing
+// removed lines with "-", added lines with "+", and unchanged lines with " ".
+func unifiedLines(before, after string) string {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			fmt.Fprintf(&out, " %s\n", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&out, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+%s\n", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		fmt.Fprintf(&out, "-%s\n", a[i])
+	}
+	for ; j < len(b); j++ {
+		fmt.Fprintf(&out, "+%s\n", b[j])
+	}
+	return out.String()
+}
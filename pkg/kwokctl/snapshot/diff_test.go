@@ -0,0 +1,92 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import "testing"
+
+func TestUnifiedLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{
+			name:   "identical",
+			before: "a\nb",
+			after:  "a\nb",
+			want:   " a\n b\n",
+		},
+		{
+			name:   "appended line",
+			before: "a",
+			after:  "a\nb",
+			want:   " a\n+b\n",
+		},
+		{
+			name:   "removed line",
+			before: "a\nb",
+			after:  "a",
+			want:   " a\n-b\n",
+		},
+		{
+			name:   "replaced middle line",
+			before: "a\nb\nc",
+			after:  "a\nx\nc",
+			want:   " a\n-b\n+x\n c\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedLines(tt.before, tt.after)
+			if got != tt.want {
+				t.Errorf("unifiedLines(%q, %q) = %q, want %q", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONDiff(t *testing.T) {
+	t.Run("identical objects produce no diff", func(t *testing.T) {
+		before := map[string]interface{}{"a": float64(1)}
+		after := map[string]interface{}{"a": float64(1)}
+
+		diff, err := jsonDiff(before, after)
+		if err != nil {
+			t.Fatalf("jsonDiff returned error: %v", err)
+		}
+		if diff != "" {
+			t.Errorf("jsonDiff = %q, want empty", diff)
+		}
+	})
+
+	t.Run("changed field is rendered as a unified diff", func(t *testing.T) {
+		before := map[string]interface{}{"a": float64(1)}
+		after := map[string]interface{}{"a": float64(2)}
+
+		diff, err := jsonDiff(before, after)
+		if err != nil {
+			t.Fatalf("jsonDiff returned error: %v", err)
+		}
+
+		want := " {\n-  \"a\": 1\n+  \"a\": 2\n }\n"
+		if diff != want {
+			t.Errorf("jsonDiff = %q, want %q", diff, want)
+		}
+	})
+}
@@ -20,27 +20,164 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/utils/pointer"
 
 	"sigs.k8s.io/kwok/pkg/log"
 	"sigs.k8s.io/kwok/pkg/utils/client"
 	"sigs.k8s.io/kwok/pkg/utils/yaml"
 )
 
+// ApplyMode controls how loader.apply writes a decoded object to the cluster.
+type ApplyMode int
+
+const (
+	// ApplyModeCreateOrUpdate creates the object if it is missing, otherwise falls
+	// back to a full update. Conflicting updates are dropped with a logged warning.
+	// This is the historical, default behavior of Load.
+	ApplyModeCreateOrUpdate ApplyMode = iota
+	// ApplyModeSSA applies the object using Kubernetes Server-Side Apply, so field
+	// ownership is tracked per FieldManager instead of being overwritten wholesale.
+	ApplyModeSSA
+)
+
+// DefaultFieldManager is the field manager used for ApplyModeSSA when
+// LoadOptions.FieldManager is left empty.
+const DefaultFieldManager = "kwok-snapshot"
+
+// LoadOptions holds the configurable behavior of Load.
+type LoadOptions struct {
+	// Mode selects how decoded objects are written to the cluster.
+	Mode ApplyMode
+	// FieldManager is the field manager to use for ApplyModeSSA.
+	// Defaults to DefaultFieldManager if empty.
+	FieldManager string
+	// Force, when true and Mode is ApplyModeSSA, takes ownership of any
+	// conflicting fields instead of failing the apply.
+	Force bool
+	// KindPriority orders the Kinds that objects are installed in, earlier
+	// entries first. Objects whose GroupKind is not listed are installed last,
+	// in a trailing bucket, after everything else. Defaults to defaultKindPriority.
+	KindPriority []schema.GroupKind
+	// Wait, when true, blocks Load until every successfully-applied object is
+	// ready, per Wait's built-in readiness predicates. Load passes no
+	// PerKindPredicates to Wait.
+	Wait bool
+	// WaitTimeout bounds how long Load waits when Wait is true.
+	// Defaults to defaultWaitTimeout.
+	WaitTimeout time.Duration
+	// DryRun, when true, makes loader.apply issue Create/Update/Patch requests
+	// with DryRunAll, so no object is actually persisted to the cluster.
+	DryRun bool
+	// SnapshotID, when non-empty, is stamped onto every loaded object as the
+	// SnapshotLabelKey label, so the whole set can later be torn down with
+	// UnloadByLabel without keeping the original snapshot file around.
+	SnapshotID string
+	// Prune, when true, turns Load into a full desired-state reconcile: after
+	// loading, every cluster resource of a filtered kind matching
+	// PruneSelector that was not part of the loaded set is deleted.
+	Prune bool
+	// PruneSelector scopes Prune to matching resources. Required, and must not
+	// be empty (i.e. must not select everything), to avoid accidental
+	// cluster-wide deletions.
+	PruneSelector labels.Selector
+	// Transformers run, in order, on every decoded object before it is
+	// applied. Built-ins are provided for namespace remapping, name
+	// prefixing/suffixing, and image/registry rewriting.
+	Transformers []Transformer
+}
+
+// LoadSummary reports what Load did, for auditing purposes.
+type LoadSummary struct {
+	// Pruned lists the resources that were deleted because Prune was enabled
+	// and they were no longer present in the loaded set.
+	Pruned []PrunedResource
+}
+
+// dryRunOf returns the metav1 DryRun value for the given LoadOptions.DryRun.
+func dryRunOf(enabled bool) []string {
+	if !enabled {
+		return nil
+	}
+	return []string{metav1.DryRunAll}
+}
+
+// defaultKindPriority is the built-in install order used when
+// LoadOptions.KindPriority is empty. It groups Kinds into phases so that, for
+// example, a Deployment referencing a ConfigMap is always installed after it,
+// and custom resources are installed only once their CRD is Established.
+var defaultKindPriority = []schema.GroupKind{
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"},
+	{Kind: "Namespace"},
+	{Kind: "ServiceAccount"},
+	{Group: "rbac.authorization.k8s.io", Kind: "Role"},
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"},
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"},
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"},
+	{Kind: "Secret"},
+	{Kind: "ConfigMap"},
+	{Kind: "PersistentVolumeClaim"},
+	{Kind: "PersistentVolume"},
+	{Group: "storage.k8s.io", Kind: "StorageClass"},
+	{Kind: "Service"},
+	{Kind: "Endpoints"},
+	{Group: "apps", Kind: "Deployment"},
+	{Group: "apps", Kind: "StatefulSet"},
+	{Group: "apps", Kind: "DaemonSet"},
+	{Group: "batch", Kind: "Job"},
+	{Group: "batch", Kind: "CronJob"},
+	{Group: "apps", Kind: "ReplicaSet"},
+	{Kind: "Pod"},
+}
+
+// crdGroupKind identifies the CRD phase, which blocks until each CRD it
+// installs becomes Established before the next phase begins.
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// crdEstablishedTimeout bounds how long the CRD phase waits for a
+// CustomResourceDefinition to become Established before moving on.
+const crdEstablishedTimeout = 30 * time.Second
+
 // Load loads the resources to cluster from the reader
-func Load(ctx context.Context, kubeconfigPath string, r io.Reader, filters []string) error {
-	l, err := newLoader(kubeconfigPath, filters)
+func Load(ctx context.Context, kubeconfigPath string, r io.Reader, filters []string, opts LoadOptions) (LoadSummary, error) {
+	var summary LoadSummary
+
+	if opts.Prune && (opts.PruneSelector == nil || opts.PruneSelector.Empty()) {
+		return summary, fmt.Errorf("prune requires a non-empty PruneSelector to avoid deleting unrelated resources")
+	}
+
+	l, err := newLoader(kubeconfigPath, filters, opts)
 	if err != nil {
-		return err
+		return summary, err
+	}
+	if err := l.Load(ctx, r); err != nil {
+		return summary, err
+	}
+
+	if opts.Wait {
+		if err := Wait(ctx, kubeconfigPath, l.applied, WaitOptions{Timeout: opts.WaitTimeout}); err != nil {
+			return summary, fmt.Errorf("failed to wait for readiness: %w", err)
+		}
+	}
+
+	if opts.Prune {
+		pruned, err := l.prune(ctx)
+		if err != nil {
+			return summary, fmt.Errorf("failed to prune: %w", err)
+		}
+		summary.Pruned = pruned
 	}
-	return l.Load(ctx, r)
+
+	return summary, nil
 }
 
 type uniqueKey struct {
@@ -54,16 +191,22 @@ type uniqueKey struct {
 // This way does not delete existing resources in the cluster,
 // which will handle the ownerReference so that the resources remain relative to each other
 type loader struct {
+	opts LoadOptions
+
 	filterMap map[schema.GroupKind]struct{}
 
 	exist   map[uniqueKey]types.UID
 	pending map[uniqueKey][]*unstructured.Unstructured
 
+	// applied collects every object that was successfully created, updated or
+	// patched, for use by features that act on the whole loaded set (Wait, Prune).
+	applied []*unstructured.Unstructured
+
 	restMapper meta.RESTMapper
 	dynClient  *dynamic.DynamicClient
 }
 
-func newLoader(kubeconfigPath string, resources []string) (*loader, error) {
+func newLoader(kubeconfigPath string, resources []string, opts LoadOptions) (*loader, error) {
 	clientset, err := client.NewClientset("", kubeconfigPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create clientset: %w", err)
@@ -86,7 +229,13 @@ func newLoader(kubeconfigPath string, resources []string) (*loader, error) {
 		}
 		filterMap[mapping.GroupVersionKind.GroupKind()] = struct{}{}
 	}
+
+	if opts.FieldManager == "" {
+		opts.FieldManager = DefaultFieldManager
+	}
+
 	return &loader{
+		opts:       opts,
 		filterMap:  filterMap,
 		exist:      make(map[uniqueKey]types.UID),
 		pending:    make(map[uniqueKey][]*unstructured.Unstructured),
@@ -100,6 +249,7 @@ func (l *loader) Load(ctx context.Context, r io.Reader) error {
 
 	decoder := yaml.NewDecoder(r)
 
+	var objs []*unstructured.Unstructured
 	err := decoder.Decode(func(obj *unstructured.Unstructured) error {
 		if err := ctx.Err(); err != nil {
 			return err
@@ -113,13 +263,23 @@ func (l *loader) Load(ctx context.Context, r io.Reader) error {
 			return nil
 		}
 
-		l.load(ctx, obj)
+		objs = append(objs, obj)
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("failed to decode objects: %w", err)
 	}
 
+	for i, phase := range l.phases(objs) {
+		for _, obj := range phase {
+			l.load(ctx, obj)
+		}
+
+		if i == l.crdPhaseIndex() {
+			l.waitForCRDsEstablished(ctx, phase)
+		}
+	}
+
 	// Print the skipped resources
 	for _, pendingObjs := range l.pending {
 		for _, pendingObj := range pendingObjs {
@@ -133,7 +293,123 @@ func (l *loader) Load(ctx context.Context, r io.Reader) error {
 	return nil
 }
 
+// kindPriority returns the configured Kind install order, falling back to
+// defaultKindPriority when LoadOptions.KindPriority is empty.
+func (l *loader) kindPriority() []schema.GroupKind {
+	if len(l.opts.KindPriority) != 0 {
+		return l.opts.KindPriority
+	}
+	return defaultKindPriority
+}
+
+// crdPhaseIndex returns the phase index that holds CustomResourceDefinitions,
+// or -1 if the configured kind priority does not list one.
+func (l *loader) crdPhaseIndex() int {
+	for i, gk := range l.kindPriority() {
+		if gk == crdGroupKind {
+			return i
+		}
+	}
+	return -1
+}
+
+// phases groups objs into install phases ordered by kindPriority. Objects
+// whose GroupKind is not listed are placed in a trailing phase, after
+// everything else. Order within a phase is preserved from the input.
+func (l *loader) phases(objs []*unstructured.Unstructured) [][]*unstructured.Unstructured {
+	priority := l.kindPriority()
+
+	index := make(map[schema.GroupKind]int, len(priority))
+	for i, gk := range priority {
+		index[gk] = i
+	}
+
+	phases := make([][]*unstructured.Unstructured, len(priority)+1)
+	for _, obj := range objs {
+		i, ok := index[obj.GroupVersionKind().GroupKind()]
+		if !ok {
+			i = len(priority)
+		}
+		phases[i] = append(phases[i], obj)
+	}
+	return phases
+}
+
+// waitForCRDsEstablished blocks until every CustomResourceDefinition in crds
+// reports its Established condition as true, or crdEstablishedTimeout elapses.
+func (l *loader) waitForCRDsEstablished(ctx context.Context, crds []*unstructured.Unstructured) {
+	logger := log.FromContext(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, crdEstablishedTimeout)
+	defer cancel()
+
+	for _, crd := range crds {
+		if err := l.waitForCRDEstablished(ctx, crd); err != nil {
+			logger.Warn("CRD not established",
+				"name", log.KObj(crd),
+				"err", err,
+			)
+		}
+	}
+}
+
+// waitForCRDEstablished polls a single CustomResourceDefinition until its
+// Established condition is true or ctx is done.
+func (l *loader) waitForCRDEstablished(ctx context.Context, crd *unstructured.Unstructured) error {
+	gvr := crd.GroupVersionKind().GroupVersion().WithResource(crd.GetKind())
+	gvr, err := l.restMapper.ResourceFor(gvr)
+	if err != nil {
+		return fmt.Errorf("failed to get resource for %s: %w", crdGroupKind, err)
+	}
+	ri := l.dynClient.Resource(gvr)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		obj, err := ri.Get(ctx, crd.GetName(), metav1.GetOptions{})
+		if err == nil && crdEstablished(obj) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// crdEstablished reports whether a CustomResourceDefinition's status
+// conditions contain an Established condition with status True.
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, found, err := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	if err != nil || !found {
+		return false
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
 func (l *loader) load(ctx context.Context, obj *unstructured.Unstructured) {
+	if err := l.transform(obj); err != nil {
+		log.FromContext(ctx).Error("failed to transform resource", err,
+			"kind", obj.GetKind(),
+			"name", log.KObj(obj),
+		)
+		return
+	}
+
+	l.stampSnapshotLabel(obj)
+
 	// If the object has owner references, we need to wait until all the owner references are created.
 	if ownerReferences := obj.GetOwnerReferences(); len(ownerReferences) != 0 {
 		allExist := true
@@ -162,6 +438,7 @@ func (l *loader) load(ctx context.Context, obj *unstructured.Unstructured) {
 	// Record the new uid
 	key := uniqueKeyFromMetadata(obj)
 	l.exist[key] = newObj.GetUID()
+	l.applied = append(l.applied, newObj)
 
 	// If there are pending objects waiting for this object, apply them.
 	if pendingObjs, ok := l.pending[key]; ok {
@@ -176,6 +453,7 @@ func (l *loader) load(ctx context.Context, obj *unstructured.Unstructured) {
 				if newObj != nil {
 					key := uniqueKeyFromMetadata(pendingObj)
 					l.exist[key] = newObj.GetUID()
+					l.applied = append(l.applied, newObj)
 				}
 			}
 		}
@@ -184,6 +462,20 @@ func (l *loader) load(ctx context.Context, obj *unstructured.Unstructured) {
 	}
 }
 
+// stampSnapshotLabel sets SnapshotLabelKey on obj when LoadOptions.SnapshotID
+// is configured, leaving obj untouched otherwise.
+func (l *loader) stampSnapshotLabel(obj *unstructured.Unstructured) {
+	if l.opts.SnapshotID == "" {
+		return
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[SnapshotLabelKey] = l.opts.SnapshotID
+	obj.SetLabels(labels)
+}
+
 func (l *loader) filter(obj *unstructured.Unstructured) bool {
 	_, ok := l.filterMap[obj.GroupVersionKind().GroupKind()]
 	return ok
@@ -212,13 +504,24 @@ func (l *loader) apply(ctx context.Context, obj *unstructured.Unstructured) *uns
 	if ns := obj.GetNamespace(); ns != "" {
 		ri = nri.Namespace(ns)
 	}
-	newObj, err := ri.Create(ctx, obj, metav1.CreateOptions{FieldValidation: "Ignore"})
+
+	if l.opts.Mode == ApplyModeSSA {
+		newObj, err := l.applySSA(ctx, ri, obj)
+		if err != nil {
+			logger.Error("failed to apply resource", err)
+			return nil
+		}
+		logger.Info("applied")
+		return newObj
+	}
+
+	newObj, err := ri.Create(ctx, obj, metav1.CreateOptions{FieldValidation: "Ignore", DryRun: dryRunOf(l.opts.DryRun)})
 	if err != nil {
 		if !apierrors.IsAlreadyExists(err) {
 			logger.Error("failed to create resource", err)
 			return nil
 		}
-		newObj, err = ri.Update(ctx, obj, metav1.UpdateOptions{FieldValidation: "Ignore"})
+		newObj, err = ri.Update(ctx, obj, metav1.UpdateOptions{FieldValidation: "Ignore", DryRun: dryRunOf(l.opts.DryRun)})
 		if err != nil {
 			if apierrors.IsConflict(err) {
 				logger.Warn("conflict")
@@ -234,6 +537,26 @@ func (l *loader) apply(ctx context.Context, obj *unstructured.Unstructured) *uns
 	return newObj
 }
 
+// applySSA applies obj to the cluster using Server-Side Apply, taking ownership
+// of conflicting fields when Force is set.
+func (l *loader) applySSA(ctx context.Context, ri dynamic.ResourceInterface, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal object: %w", err)
+	}
+
+	patchOpts := metav1.PatchOptions{
+		FieldManager:    l.opts.FieldManager,
+		FieldValidation: "Ignore",
+		DryRun:          dryRunOf(l.opts.DryRun),
+	}
+	if l.opts.Force {
+		patchOpts.Force = pointer.Bool(true)
+	}
+
+	return ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, patchOpts)
+}
+
 func (l *loader) hasAllOwnerReferences(obj *unstructured.Unstructured) bool {
 	ownerReferences := obj.GetOwnerReferences()
 	if len(ownerReferences) == 0 {
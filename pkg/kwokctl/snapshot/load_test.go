@@ -0,0 +1,94 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newPhaseObj(apiVersion, kind, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+	}}
+}
+
+func TestCrdPhaseIndex(t *testing.T) {
+	t.Run("default priority has CRDs first", func(t *testing.T) {
+		l := &loader{opts: LoadOptions{}}
+		if got := l.crdPhaseIndex(); got != 0 {
+			t.Errorf("crdPhaseIndex() = %d, want 0", got)
+		}
+	})
+
+	t.Run("custom priority without CRDs reports -1", func(t *testing.T) {
+		l := &loader{opts: LoadOptions{KindPriority: []schema.GroupKind{{Kind: "Namespace"}}}}
+		if got := l.crdPhaseIndex(); got != -1 {
+			t.Errorf("crdPhaseIndex() = %d, want -1", got)
+		}
+	})
+}
+
+func TestPhases(t *testing.T) {
+	ns := newPhaseObj("v1", "Namespace", "ns1")
+	cm := newPhaseObj("v1", "ConfigMap", "cm1")
+	crd := newPhaseObj("apiextensions.k8s.io/v1", "CustomResourceDefinition", "widgets.example.com")
+	unknown := newPhaseObj("example.com/v1", "Widget", "w1")
+
+	l := &loader{opts: LoadOptions{}}
+	phases := l.phases([]*unstructured.Unstructured{cm, unknown, ns, crd})
+
+	priority := l.kindPriority()
+	if len(phases) != len(priority)+1 {
+		t.Fatalf("phases returned %d phases, want %d", len(phases), len(priority)+1)
+	}
+
+	crdPhase := l.crdPhaseIndex()
+	if len(phases[crdPhase]) != 1 || phases[crdPhase][0].GetName() != "widgets.example.com" {
+		t.Errorf("CRD phase = %v, want [widgets.example.com]", phases[crdPhase])
+	}
+
+	nsPhaseIndex := -1
+	cmPhaseIndex := -1
+	for i, gk := range priority {
+		if gk.Kind == "Namespace" {
+			nsPhaseIndex = i
+		}
+		if gk.Kind == "ConfigMap" {
+			cmPhaseIndex = i
+		}
+	}
+	if len(phases[nsPhaseIndex]) != 1 || phases[nsPhaseIndex][0].GetName() != "ns1" {
+		t.Errorf("Namespace phase = %v, want [ns1]", phases[nsPhaseIndex])
+	}
+	if len(phases[cmPhaseIndex]) != 1 || phases[cmPhaseIndex][0].GetName() != "cm1" {
+		t.Errorf("ConfigMap phase = %v, want [cm1]", phases[cmPhaseIndex])
+	}
+
+	// Objects whose GroupKind isn't in the priority list land in the
+	// trailing phase, after everything else.
+	trailing := phases[len(priority)]
+	if len(trailing) != 1 || trailing[0].GetName() != "w1" {
+		t.Errorf("trailing phase = %v, want [w1]", trailing)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"sigs.k8s.io/kwok/pkg/log"
+)
+
+// PrunedResource identifies a resource that Prune removed because it matched
+// LoadOptions.PruneSelector but was not part of the loaded set.
+type PrunedResource struct {
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+}
+
+// prune lists every resource of a filtered kind matching l.opts.PruneSelector
+// and deletes any whose (Kind, namespace, name) is not in l.applied.
+func (l *loader) prune(ctx context.Context) ([]PrunedResource, error) {
+	loaded := make(map[deletionKey]struct{}, len(l.applied))
+	for _, obj := range l.applied {
+		loaded[deletionKey{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}] = struct{}{}
+	}
+
+	logger := log.FromContext(ctx)
+	var pruned []PrunedResource
+	for gk := range l.filterMap {
+		gvr := gk.WithVersion("").GroupVersion().WithResource(gk.Kind)
+		gvr, err := l.restMapper.ResourceFor(gvr)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to get resource for %s: %w", gk, err)
+		}
+
+		list, err := l.dynClient.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: l.opts.PruneSelector.String()})
+		if err != nil {
+			return pruned, fmt.Errorf("failed to list %s: %w", gk, err)
+		}
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			key := deletionKey{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+			if _, ok := loaded[key]; ok {
+				continue
+			}
+
+			if err := l.deleteOne(ctx, gvr, obj.GetNamespace(), obj.GetName()); err != nil {
+				logger.Warn("failed to prune resource",
+					"kind", obj.GetKind(),
+					"name", log.KObj(obj),
+					"err", err,
+				)
+				continue
+			}
+			logger.Info("pruned",
+				"kind", obj.GetKind(),
+				"name", log.KObj(obj),
+			)
+			pruned = append(pruned, PrunedResource{
+				GroupVersionKind: obj.GroupVersionKind(),
+				Namespace:        obj.GetNamespace(),
+				Name:             obj.GetName(),
+			})
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Transformer mutates a decoded object before it is applied. Transformers run
+// in order, and an object's name (with its apiVersion and kind) after the
+// pipeline runs is what the loader's owner-reference stitching keys off, so a
+// Transformer that renames an object must rename its owner references the
+// same way.
+type Transformer func(obj *unstructured.Unstructured) error
+
+// transform runs l.opts.Transformers over obj, in order.
+func (l *loader) transform(obj *unstructured.Unstructured) error {
+	for _, t := range l.opts.Transformers {
+		if err := t(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NamespaceMappingTransformer remaps obj.GetNamespace() according to mapping,
+// leaving cluster-scoped objects and namespaces not present in mapping
+// unchanged.
+func NamespaceMappingTransformer(mapping map[string]string) Transformer {
+	return func(obj *unstructured.Unstructured) error {
+		ns := obj.GetNamespace()
+		if ns == "" {
+			return nil
+		}
+		if newNS, ok := mapping[ns]; ok {
+			obj.SetNamespace(newNS)
+		}
+		return nil
+	}
+}
+
+// NameTransformer prefixes and suffixes obj's name, and every owner
+// reference's name, so the same snapshot can be loaded multiple times
+// side-by-side into one cluster without name collisions.
+func NameTransformer(prefix, suffix string) Transformer {
+	return func(obj *unstructured.Unstructured) error {
+		obj.SetName(prefix + obj.GetName() + suffix)
+
+		ownerReferences := obj.GetOwnerReferences()
+		for i := range ownerReferences {
+			ownerReferences[i].Name = prefix + ownerReferences[i].Name + suffix
+		}
+		obj.SetOwnerReferences(ownerReferences)
+		return nil
+	}
+}
+
+// ImageRewriter rewrites a single container image reference.
+type ImageRewriter func(image string) string
+
+// ImageTransformer rewrites every container and init container image inside
+// obj using rewrite. It understands Pods directly, and the Pod template of
+// Deployments, StatefulSets, DaemonSets, Jobs, CronJobs and ReplicaSets.
+func ImageTransformer(rewrite ImageRewriter) Transformer {
+	return func(obj *unstructured.Unstructured) error {
+		path := podSpecPath(obj)
+		if path == nil {
+			return nil
+		}
+		for _, field := range []string{"containers", "initContainers"} {
+			if err := rewriteImages(obj, rewrite, append(path, field)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RegistryMappingTransformer rewrites container images whose registry prefix
+// matches a key in mapping to the corresponding value, leaving the rest of
+// the image reference (repository, tag or digest) unchanged. When more than
+// one key matches (e.g. "docker.io" and "docker.io/library"), the longest
+// matching key wins, and ties are broken lexically, so the result is
+// deterministic regardless of map iteration order.
+func RegistryMappingTransformer(mapping map[string]string) Transformer {
+	from := make([]string, 0, len(mapping))
+	for k := range mapping {
+		from = append(from, k)
+	}
+	sort.Slice(from, func(i, j int) bool {
+		if len(from[i]) != len(from[j]) {
+			return len(from[i]) > len(from[j])
+		}
+		return from[i] < from[j]
+	})
+
+	return ImageTransformer(func(image string) string {
+		for _, prefix := range from {
+			if strings.HasPrefix(image, prefix+"/") {
+				return mapping[prefix] + strings.TrimPrefix(image, prefix)
+			}
+		}
+		return image
+	})
+}
+
+// podSpecPath returns the field path to obj's PodSpec, or nil if obj's Kind
+// does not carry one.
+func podSpecPath(obj *unstructured.Unstructured) []string {
+	switch obj.GetKind() {
+	case "Pod":
+		return []string{"spec"}
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	case "Deployment", "StatefulSet", "DaemonSet", "Job", "ReplicaSet":
+		return []string{"spec", "template", "spec"}
+	default:
+		return nil
+	}
+}
+
+// rewriteImages rewrites the "image" field of every container at path inside
+// obj using rewrite.
+func rewriteImages(obj *unstructured.Unstructured, rewrite ImageRewriter, path []string) error {
+	containers, found, err := unstructured.NestedSlice(obj.Object, path...)
+	if err != nil || !found {
+		return nil
+	}
+
+	for i := range containers {
+		container, ok := containers[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		image, _, _ := unstructured.NestedString(container, "image")
+		if image == "" {
+			continue
+		}
+		container["image"] = rewrite(image)
+		containers[i] = container
+	}
+
+	if err := unstructured.SetNestedSlice(obj.Object, containers, path...); err != nil {
+		return fmt.Errorf("failed to set images at %v: %w", path, err)
+	}
+	return nil
+}
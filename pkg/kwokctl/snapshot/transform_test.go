@@ -0,0 +1,245 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// firstContainerImage reads containers[0].image from a NestedSlice field,
+// since unstructured's Nested* helpers don't support indexing into slices by
+// path component.
+func firstContainerImage(obj *unstructured.Unstructured, path ...string) string {
+	containers, found, err := unstructured.NestedSlice(obj.Object, path...)
+	if err != nil || !found || len(containers) == 0 {
+		return ""
+	}
+	container, ok := containers[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	image, _, _ := unstructured.NestedString(container, "image")
+	return image
+}
+
+func newTransformObj(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+	}}
+	if namespace != "" {
+		obj.SetNamespace(namespace)
+	}
+	return obj
+}
+
+func TestNamespaceMappingTransformer(t *testing.T) {
+	transform := NamespaceMappingTransformer(map[string]string{"source": "target"})
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "mapped namespace is rewritten", in: "source", want: "target"},
+		{name: "unmapped namespace is unchanged", in: "other", want: "other"},
+		{name: "cluster-scoped object is unchanged", in: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newTransformObj("v1", "ConfigMap", tt.in, "cm1")
+			if err := transform(obj); err != nil {
+				t.Fatalf("transform returned error: %v", err)
+			}
+			if got := obj.GetNamespace(); got != tt.want {
+				t.Errorf("namespace = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameTransformer(t *testing.T) {
+	transform := NameTransformer("pre-", "-post")
+
+	obj := newTransformObj("v1", "Pod", "default", "p1")
+	obj.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs1"},
+	})
+
+	if err := transform(obj); err != nil {
+		t.Fatalf("transform returned error: %v", err)
+	}
+
+	if got, want := obj.GetName(), "pre-p1-post"; got != want {
+		t.Errorf("name = %q, want %q", got, want)
+	}
+	owners := obj.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Name != "pre-rs1-post" {
+		t.Errorf("owner references = %v, want owner named pre-rs1-post", owners)
+	}
+}
+
+// TestNameTransformerOwnerStitching exercises the promise made by
+// NameTransformer's doc comment: after the loader pipeline renames an owner
+// and its dependent, the dependent's owner reference still resolves to the
+// owner's post-apply UID via the loader's existing uniqueKey-based stitching.
+func TestNameTransformerOwnerStitching(t *testing.T) {
+	l := &loader{
+		opts:  LoadOptions{Transformers: []Transformer{NameTransformer("pre-", "-post")}},
+		exist: make(map[uniqueKey]types.UID),
+	}
+
+	owner := newTransformObj("v1", "ConfigMap", "default", "owner1")
+	if err := l.transform(owner); err != nil {
+		t.Fatalf("transform(owner) returned error: %v", err)
+	}
+	if got, want := owner.GetName(), "pre-owner1-post"; got != want {
+		t.Fatalf("owner name = %q, want %q", got, want)
+	}
+
+	// Simulate what load does after a successful apply: record the
+	// post-transform identity against the UID the cluster assigned.
+	l.exist[uniqueKeyFromMetadata(owner)] = types.UID("owner-uid-123")
+
+	child := newTransformObj("v1", "Pod", "default", "child1")
+	child.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "owner1"},
+	})
+	if err := l.transform(child); err != nil {
+		t.Fatalf("transform(child) returned error: %v", err)
+	}
+	l.updateOwnerReferences(child)
+
+	owners := child.GetOwnerReferences()
+	if len(owners) != 1 {
+		t.Fatalf("owner references = %v, want exactly one", owners)
+	}
+	if owners[0].Name != "pre-owner1-post" {
+		t.Errorf("owner reference name = %q, want %q", owners[0].Name, "pre-owner1-post")
+	}
+	if owners[0].UID != types.UID("owner-uid-123") {
+		t.Errorf("owner reference UID = %q, want %q", owners[0].UID, "owner-uid-123")
+	}
+}
+
+func TestRegistryMappingTransformer(t *testing.T) {
+	mapping := map[string]string{
+		"docker.io":         "myregistry.example.com",
+		"docker.io/library": "myregistry.example.com/lib",
+	}
+
+	tests := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{
+			name:  "longest matching prefix wins",
+			image: "docker.io/library/nginx:1.25",
+			want:  "myregistry.example.com/lib/nginx:1.25",
+		},
+		{
+			name:  "shorter prefix used when longer doesn't match",
+			image: "docker.io/other/nginx:1.25",
+			want:  "myregistry.example.com/other/nginx:1.25",
+		},
+		{
+			name:  "no matching registry is left untouched",
+			image: "quay.io/other/nginx:1.25",
+			want:  "quay.io/other/nginx:1.25",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Build the transformer fresh each time: map iteration order is
+			// randomized on every range, so this also guards against the
+			// prefix-matching order being nondeterministic.
+			transform := RegistryMappingTransformer(mapping)
+
+			obj := newTransformObj("v1", "Pod", "default", "p1")
+			if err := unstructured.SetNestedSlice(obj.Object, []interface{}{
+				map[string]interface{}{"name": "c", "image": tt.image},
+			}, "spec", "containers"); err != nil {
+				t.Fatalf("failed to set containers: %v", err)
+			}
+
+			if err := transform(obj); err != nil {
+				t.Fatalf("transform returned error: %v", err)
+			}
+
+			got := firstContainerImage(obj, "spec", "containers")
+			if got != tt.want {
+				t.Errorf("image = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageTransformerPodSpecPath(t *testing.T) {
+	rewrite := func(image string) string { return "rewritten/" + image }
+
+	tests := []struct {
+		name        string
+		kind        string
+		path        []string
+		wantRewrite bool
+	}{
+		{name: "Pod", kind: "Pod", path: []string{"spec"}, wantRewrite: true},
+		{name: "CronJob", kind: "CronJob", path: []string{"spec", "jobTemplate", "spec", "template", "spec"}, wantRewrite: true},
+		{name: "Deployment", kind: "Deployment", path: []string{"spec", "template", "spec"}, wantRewrite: true},
+		{name: "StatefulSet", kind: "StatefulSet", path: []string{"spec", "template", "spec"}, wantRewrite: true},
+		{name: "DaemonSet", kind: "DaemonSet", path: []string{"spec", "template", "spec"}, wantRewrite: true},
+		{name: "Job", kind: "Job", path: []string{"spec", "template", "spec"}, wantRewrite: true},
+		{name: "ReplicaSet", kind: "ReplicaSet", path: []string{"spec", "template", "spec"}, wantRewrite: true},
+		{name: "ConfigMap has no PodSpec", kind: "ConfigMap", path: nil, wantRewrite: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newTransformObj("v1", tt.kind, "default", "x1")
+			if tt.path != nil {
+				if err := unstructured.SetNestedSlice(obj.Object, []interface{}{
+					map[string]interface{}{"name": "c", "image": "nginx:1.25"},
+				}, append(append([]string{}, tt.path...), "containers")...); err != nil {
+					t.Fatalf("failed to set containers: %v", err)
+				}
+			}
+
+			transform := ImageTransformer(rewrite)
+			if err := transform(obj); err != nil {
+				t.Fatalf("transform returned error: %v", err)
+			}
+
+			if !tt.wantRewrite {
+				return
+			}
+			got := firstContainerImage(obj, append(append([]string{}, tt.path...), "containers")...)
+			if want := "rewritten/nginx:1.25"; got != want {
+				t.Errorf("image = %q, want %q", got, want)
+			}
+		})
+	}
+}
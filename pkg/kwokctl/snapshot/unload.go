@@ -0,0 +1,259 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"sigs.k8s.io/kwok/pkg/log"
+	"sigs.k8s.io/kwok/pkg/utils/yaml"
+)
+
+// SnapshotLabelKey, when stamped onto a loaded object via LoadOptions.SnapshotID,
+// identifies the snapshot it came from, so the whole set can later be torn
+// down with UnloadByLabel without keeping the original snapshot file around.
+const SnapshotLabelKey = "kwok.sigs.k8s.io/snapshot"
+
+// Unload reads the YAML stream from r and deletes the objects it describes
+// from the cluster, in reverse install order: children before owners,
+// workloads before CRDs/Namespaces. Objects are matched against filters
+// exactly as in Load. Objects already missing from the cluster are ignored.
+func Unload(ctx context.Context, kubeconfigPath string, r io.Reader, filters []string) error {
+	l, err := newLoader(kubeconfigPath, filters, LoadOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger := log.FromContext(ctx)
+	decoder := yaml.NewDecoder(r)
+
+	var objs []*unstructured.Unstructured
+	err = decoder.Decode(func(obj *unstructured.Unstructured) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !l.filter(obj) {
+			logger.Info("skipped",
+				"resource", "filtered",
+				"kind", obj.GetKind(),
+				"name", log.KObj(obj),
+			)
+			return nil
+		}
+		objs = append(objs, obj)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode objects: %w", err)
+	}
+
+	for _, obj := range l.deletionOrder(objs) {
+		l.delete(ctx, obj)
+	}
+	return nil
+}
+
+// UnloadByLabel deletes every object of the filtered kinds that matches
+// selector, discarding the need for the original snapshot file. This is
+// useful for tearing down a scenario that was loaded with a
+// LoadOptions.SnapshotID label.
+func UnloadByLabel(ctx context.Context, kubeconfigPath string, filters []string, selector labels.Selector) error {
+	if selector == nil || selector.Empty() {
+		return fmt.Errorf("UnloadByLabel requires a non-empty selector to avoid deleting unrelated resources")
+	}
+
+	l, err := newLoader(kubeconfigPath, filters, LoadOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger := log.FromContext(ctx)
+	for gk := range l.filterMap {
+		if err := l.deleteByLabel(ctx, gk, selector); err != nil {
+			logger.Warn("failed to delete by label",
+				"kind", gk.Kind,
+				"err", err,
+			)
+		}
+	}
+	return nil
+}
+
+// deleteByLabel lists every object of gk matching selector, across all
+// namespaces, and deletes each one.
+func (l *loader) deleteByLabel(ctx context.Context, gk schema.GroupKind, selector labels.Selector) error {
+	gvr := gk.WithVersion("").GroupVersion().WithResource(gk.Kind)
+	gvr, err := l.restMapper.ResourceFor(gvr)
+	if err != nil {
+		return fmt.Errorf("failed to get resource for %s: %w", gk, err)
+	}
+
+	list, err := l.dynClient.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", gk, err)
+	}
+
+	logger := log.FromContext(ctx)
+	for i := range list.Items {
+		obj := &list.Items[i]
+		if err := l.deleteOne(ctx, gvr, obj.GetNamespace(), obj.GetName()); err != nil {
+			logger.Warn("failed to delete resource",
+				"kind", obj.GetKind(),
+				"name", log.KObj(obj),
+				"err", err,
+			)
+			continue
+		}
+		logger.Info("deleted",
+			"kind", obj.GetKind(),
+			"name", log.KObj(obj),
+		)
+	}
+	return nil
+}
+
+// delete resolves obj's resource and deletes it from the cluster.
+func (l *loader) delete(ctx context.Context, obj *unstructured.Unstructured) {
+	logger := log.FromContext(ctx)
+	logger = logger.With(
+		"kind", obj.GetKind(),
+		"name", log.KObj(obj),
+	)
+
+	gvr := obj.GroupVersionKind().GroupVersion().WithResource(obj.GetKind())
+	gvr, err := l.restMapper.ResourceFor(gvr)
+	if err != nil {
+		logger.Error("failed to get resource", err)
+		return
+	}
+
+	if err := l.deleteOne(ctx, gvr, obj.GetNamespace(), obj.GetName()); err != nil {
+		logger.Error("failed to delete resource", err)
+		return
+	}
+	logger.Info("deleted")
+}
+
+// deleteOne deletes a single resource with Foreground propagation, treating a
+// missing resource as success.
+func (l *loader) deleteOne(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) error {
+	nri := l.dynClient.Resource(gvr)
+	var ri dynamic.ResourceInterface = nri
+	if namespace != "" {
+		ri = nri.Namespace(namespace)
+	}
+
+	propagation := metav1.DeletePropagationForeground
+	err := ri.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// deletionKey identifies an object by Kind/Namespace/Name for deletion
+// ordering, where ownerReference.UID from a snapshot file may not match the
+// UID the object is reloaded with.
+type deletionKey struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// deletionOrder returns objs ordered so that children are deleted before
+// their owners, and, among objects with no dependency relationship, in the
+// reverse of their install Kind-priority (workloads before CRDs/Namespaces).
+func (l *loader) deletionOrder(objs []*unstructured.Unstructured) []*unstructured.Unstructured {
+	priority := l.kindPriority()
+	index := make(map[schema.GroupKind]int, len(priority))
+	for i, gk := range priority {
+		index[gk] = i
+	}
+	rank := func(obj *unstructured.Unstructured) int {
+		if i, ok := index[obj.GroupVersionKind().GroupKind()]; ok {
+			return i
+		}
+		return len(priority)
+	}
+
+	type node struct {
+		obj     *unstructured.Unstructured
+		key     deletionKey
+		pending int
+	}
+
+	keyOf := func(obj *unstructured.Unstructured) deletionKey {
+		return deletionKey{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	}
+
+	remaining := make(map[deletionKey]*node, len(objs))
+	for _, obj := range objs {
+		remaining[keyOf(obj)] = &node{obj: obj, key: keyOf(obj)}
+	}
+	for _, obj := range objs {
+		for _, ownerRef := range obj.GetOwnerReferences() {
+			ownerKey := deletionKey{Kind: ownerRef.Kind, Namespace: obj.GetNamespace(), Name: ownerRef.Name}
+			if owner, ok := remaining[ownerKey]; ok {
+				owner.pending++
+			}
+		}
+	}
+
+	ordered := make([]*unstructured.Unstructured, 0, len(objs))
+	for len(remaining) > 0 {
+		var ready []*node
+		for _, n := range remaining {
+			if n.pending == 0 {
+				ready = append(ready, n)
+			}
+		}
+		if len(ready) == 0 {
+			// A dependency cycle: break it by taking the highest-ranked of
+			// whatever is left.
+			for _, n := range remaining {
+				ready = append(ready, n)
+			}
+		}
+
+		sort.Slice(ready, func(i, j int) bool {
+			return rank(ready[i].obj) > rank(ready[j].obj)
+		})
+
+		// Take only the highest-ranked ready object, then recompute: deleting
+		// it may free an owner that outranks the other ready objects.
+		n := ready[0]
+		ordered = append(ordered, n.obj)
+		delete(remaining, n.key)
+		for _, ownerRef := range n.obj.GetOwnerReferences() {
+			ownerKey := deletionKey{Kind: ownerRef.Kind, Namespace: n.obj.GetNamespace(), Name: ownerRef.Name}
+			if owner, ok := remaining[ownerKey]; ok {
+				owner.pending--
+			}
+		}
+	}
+	return ordered
+}
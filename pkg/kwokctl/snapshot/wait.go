@@ -0,0 +1,373 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"sigs.k8s.io/kwok/pkg/log"
+	"sigs.k8s.io/kwok/pkg/utils/client"
+)
+
+// ReadinessPredicate reports whether obj, as currently observed in the
+// cluster, is ready.
+type ReadinessPredicate func(obj *unstructured.Unstructured) (bool, error)
+
+// WaitOptions holds the configurable behavior of Wait.
+type WaitOptions struct {
+	// Timeout bounds how long Wait polls before giving up.
+	// Defaults to defaultWaitTimeout.
+	Timeout time.Duration
+	// Interval is the polling period. Defaults to defaultWaitInterval.
+	Interval time.Duration
+	// PerKindPredicates overrides or extends the built-in readiness predicates,
+	// keyed by GroupKind.
+	PerKindPredicates map[schema.GroupKind]ReadinessPredicate
+}
+
+const (
+	// defaultWaitTimeout is used when WaitOptions.Timeout is zero.
+	defaultWaitTimeout = 5 * time.Minute
+	// defaultWaitInterval is used when WaitOptions.Interval is zero.
+	defaultWaitInterval = 2 * time.Second
+)
+
+// NotReadyError is returned by Wait when one or more objects never became
+// ready before the timeout elapsed.
+type NotReadyError struct {
+	// Objects lists the "kind/namespace/name" of each object that was not ready.
+	Objects []string
+}
+
+// Error implements error.
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("%d object(s) not ready: %s", len(e.Objects), strings.Join(e.Objects, ", "))
+}
+
+// FailedError is returned by Wait as soon as an object reaches a terminal
+// failure state (e.g. a Job's Failed condition) that it will never recover
+// from, instead of blocking until opts.Timeout elapses.
+type FailedError struct {
+	Kind, Namespace, Name, Reason string
+}
+
+// Error implements error.
+func (e *FailedError) Error() string {
+	return fmt.Sprintf("%s/%s/%s failed: %s", e.Kind, e.Namespace, e.Name, e.Reason)
+}
+
+// terminalFailureError is returned by a ReadinessPredicate to signal that its
+// object has reached a terminal failure state and will never become ready,
+// so Wait should stop polling it and fail immediately.
+type terminalFailureError struct {
+	reason string
+}
+
+// Error implements error.
+func (e *terminalFailureError) Error() string {
+	return e.reason
+}
+
+// Wait blocks until every object in objects satisfies its readiness
+// predicate, or returns a *NotReadyError once opts.Timeout elapses. Objects
+// whose GroupKind has no built-in or user-supplied predicate are treated as
+// ready as soon as they exist.
+func Wait(ctx context.Context, kubeconfigPath string, objects []*unstructured.Unstructured, opts WaitOptions) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultWaitTimeout
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultWaitInterval
+	}
+
+	clientset, err := client.NewClientset("", kubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to create clientset: %w", err)
+	}
+	restMapper, err := clientset.ToRESTMapper()
+	if err != nil {
+		return fmt.Errorf("failed to create rest mapper: %w", err)
+	}
+	dynClient, err := clientset.ToDynamicClient()
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	w := &waiter{
+		restMapper: restMapper,
+		dynClient:  dynClient,
+		predicates: mergePredicates(defaultReadinessPredicates, opts.PerKindPredicates),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	remaining := objects
+	for {
+		var err error
+		remaining, err = w.notReady(ctx, remaining)
+		if err != nil {
+			return err
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			notReady := make([]string, 0, len(remaining))
+			for _, obj := range remaining {
+				notReady = append(notReady, fmt.Sprintf("%s/%s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName()))
+			}
+			return &NotReadyError{Objects: notReady}
+		case <-ticker.C:
+		}
+	}
+}
+
+type waiter struct {
+	restMapper meta.RESTMapper
+	dynClient  *dynamic.DynamicClient
+	predicates map[schema.GroupKind]ReadinessPredicate
+}
+
+// notReady returns the subset of objects that are not yet ready, logging
+// progress for the rest. It returns a *FailedError as soon as any object
+// reaches a terminal failure state, without waiting for the rest.
+func (w *waiter) notReady(ctx context.Context, objects []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	logger := log.FromContext(ctx)
+	var remaining []*unstructured.Unstructured
+	for _, obj := range objects {
+		ready, err := w.isReady(ctx, obj)
+		if err != nil {
+			var failure *terminalFailureError
+			if errors.As(err, &failure) {
+				return nil, &FailedError{
+					Kind:      obj.GetKind(),
+					Namespace: obj.GetNamespace(),
+					Name:      obj.GetName(),
+					Reason:    failure.reason,
+				}
+			}
+			logger.Warn("failed to check readiness",
+				"kind", obj.GetKind(),
+				"name", log.KObj(obj),
+				"err", err,
+			)
+			remaining = append(remaining, obj)
+			continue
+		}
+		if !ready {
+			remaining = append(remaining, obj)
+			continue
+		}
+		logger.Info("ready",
+			"kind", obj.GetKind(),
+			"name", log.KObj(obj),
+		)
+	}
+	return remaining, nil
+}
+
+func (w *waiter) isReady(ctx context.Context, obj *unstructured.Unstructured) (bool, error) {
+	gvr := obj.GroupVersionKind().GroupVersion().WithResource(obj.GetKind())
+	gvr, err := w.restMapper.ResourceFor(gvr)
+	if err != nil {
+		return false, fmt.Errorf("failed to get resource: %w", err)
+	}
+
+	nri := w.dynClient.Resource(gvr)
+	var ri dynamic.ResourceInterface = nri
+	if ns := obj.GetNamespace(); ns != "" {
+		ri = nri.Namespace(ns)
+	}
+
+	live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	// Service readiness depends on a second object (its Endpoints), so it
+	// cannot be expressed as a plain ReadinessPredicate; handle it directly.
+	if obj.GroupVersionKind().GroupKind() == (schema.GroupKind{Kind: "Service"}) {
+		if _, overridden := w.predicates[schema.GroupKind{Kind: "Service"}]; !overridden {
+			return w.serviceReady(ctx, live)
+		}
+	}
+
+	predicate, ok := w.predicates[obj.GroupVersionKind().GroupKind()]
+	if !ok {
+		predicate = func(*unstructured.Unstructured) (bool, error) { return true, nil }
+	}
+	return predicate(live)
+}
+
+// serviceReady reports whether svc has at least one ready backing endpoint.
+// ExternalName services have no endpoints of their own and are always ready.
+func (w *waiter) serviceReady(ctx context.Context, svc *unstructured.Unstructured) (bool, error) {
+	serviceType, _, _ := unstructured.NestedString(svc.Object, "spec", "type")
+	if serviceType == "ExternalName" {
+		return true, nil
+	}
+
+	endpointsGVR := schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}
+	endpoints, err := w.dynClient.Resource(endpointsGVR).Namespace(svc.GetNamespace()).Get(ctx, svc.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get endpoints for %s: %w", log.KObj(svc), err)
+	}
+
+	subsets, found, err := unstructured.NestedSlice(endpoints.Object, "subsets")
+	if err != nil || !found {
+		return false, nil
+	}
+	for _, s := range subsets {
+		subset, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		addresses, _, _ := unstructured.NestedSlice(subset, "addresses")
+		if len(addresses) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func mergePredicates(base, overrides map[schema.GroupKind]ReadinessPredicate) map[schema.GroupKind]ReadinessPredicate {
+	merged := make(map[schema.GroupKind]ReadinessPredicate, len(base)+len(overrides))
+	for gk, p := range base {
+		merged[gk] = p
+	}
+	for gk, p := range overrides {
+		merged[gk] = p
+	}
+	return merged
+}
+
+// defaultReadinessPredicates are the built-in per-Kind readiness checks used
+// when WaitOptions.PerKindPredicates does not override them.
+var defaultReadinessPredicates = map[schema.GroupKind]ReadinessPredicate{
+	{Group: "apps", Kind: "Deployment"}: func(obj *unstructured.Unstructured) (bool, error) {
+		if !observedGenerationCurrent(obj) {
+			return false, nil
+		}
+		replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+		available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+		return available >= replicas, nil
+	},
+	{Group: "apps", Kind: "StatefulSet"}: func(obj *unstructured.Unstructured) (bool, error) {
+		if !observedGenerationCurrent(obj) {
+			return false, nil
+		}
+		replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+		ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+		return ready >= replicas, nil
+	},
+	{Group: "apps", Kind: "DaemonSet"}: func(obj *unstructured.Unstructured) (bool, error) {
+		if !observedGenerationCurrent(obj) {
+			return false, nil
+		}
+		desired, _, _ := unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+		ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "numberReady")
+		return ready >= desired, nil
+	},
+	{Kind: "Pod"}: func(obj *unstructured.Unstructured) (bool, error) {
+		return conditionTrue(obj, "Ready"), nil
+	},
+	{Group: "batch", Kind: "Job"}: func(obj *unstructured.Unstructured) (bool, error) {
+		if conditionTrue(obj, "Failed") {
+			return false, &terminalFailureError{reason: conditionMessage(obj, "Failed")}
+		}
+		return conditionTrue(obj, "Complete"), nil
+	},
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}: func(obj *unstructured.Unstructured) (bool, error) {
+		return crdEstablished(obj), nil
+	},
+	{Kind: "PersistentVolumeClaim"}: func(obj *unstructured.Unstructured) (bool, error) {
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		return phase == "Bound", nil
+	},
+	// Service is handled by (*waiter).serviceReady instead of a predicate here,
+	// since it needs to look up a second object (the Service's Endpoints).
+}
+
+// observedGenerationCurrent reports whether obj's status reflects its most
+// recent spec, so that stale status left over from before a re-apply isn't
+// mistaken for readiness.
+func observedGenerationCurrent(obj *unstructured.Unstructured) bool {
+	observedGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	return observedGeneration >= obj.GetGeneration()
+}
+
+// conditionTrue reports whether obj's status.conditions contains a condition
+// of the given type with status True.
+func conditionTrue(obj *unstructured.Unstructured, conditionType string) bool {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil || !found {
+		return false
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if condition["type"] == conditionType && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionMessage returns the message (falling back to the reason) of the
+// condition of the given type, for use in a failure error. Returns
+// conditionType if neither is set.
+func conditionMessage(obj *unstructured.Unstructured, conditionType string) string {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil || !found {
+		return conditionType
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		if message, _ := condition["message"].(string); message != "" {
+			return message
+		}
+		if reason, _ := condition["reason"].(string); reason != "" {
+			return reason
+		}
+	}
+	return conditionType
+}